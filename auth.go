@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errInvalidToken = errors.New("invalid room token")
+	errTokenExpired = errors.New("room token expired")
+)
+
+// allowedOrigins holds the patterns from -allowed-origins, e.g.
+// "https://example.com,https://*.example.com". A "*" component matches any
+// sequence of characters, so "*.example.com" matches subdomains and a bare
+// "*" matches every origin.
+var allowedOrigins []string
+
+func parseAllowedOrigins(csv string) []string {
+	var patterns []string
+	for _, p := range strings.Split(csv, ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// originAllowed reports whether origin matches the configured allowlist.
+// Requests with no Origin header (non-browser clients) are always allowed;
+// everything else must match a configured pattern.
+func originAllowed(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	for _, pattern := range allowedOrigins {
+		if matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchOrigin(pattern, origin string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if idx := strings.Index(pattern, "*."); idx >= 0 {
+		prefix := pattern[:idx]   // e.g. "https://"
+		suffix := pattern[idx+1:] // e.g. ".example.com" (keep leading dot)
+		apex := prefix + suffix[1:]
+		return strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) || origin == apex
+	}
+	return pattern == origin
+}
+
+func checkOrigin(r *http.Request) bool {
+	return originAllowed(r.Header.Get("Origin"))
+}
+
+// roomToken is the verified payload of a signed room-access token.
+type roomToken struct {
+	roomID   string
+	expiry   time.Time
+	maxPeers int
+}
+
+// signRoomToken builds a token of the form "<payload>.<sig>" where payload
+// is base64url(roomID|expiryUnix|maxPeers) and sig is the base64url HMAC-SHA256
+// of the payload under secret. Used by tests and any token-issuing tooling.
+func signRoomToken(secret []byte, roomID string, expiry time.Time, maxPeers int) string {
+	payload := fmt.Sprintf("%s|%d|%d", roomID, expiry.Unix(), maxPeers)
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(signPayload(secret, encoded))
+}
+
+func signPayload(secret []byte, encodedPayload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encodedPayload))
+	return mac.Sum(nil)
+}
+
+// verifyRoomToken checks the signature and expiry of a token produced by
+// signRoomToken and returns its decoded fields.
+func verifyRoomToken(secret []byte, token string) (roomToken, error) {
+	encoded, sigPart, ok := strings.Cut(token, ".")
+	if !ok {
+		return roomToken{}, errInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigPart)
+	if err != nil {
+		return roomToken{}, errInvalidToken
+	}
+	if !hmac.Equal(sig, signPayload(secret, encoded)) {
+		return roomToken{}, errInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return roomToken{}, errInvalidToken
+	}
+	fields := strings.Split(string(payload), "|")
+	if len(fields) != 3 {
+		return roomToken{}, errInvalidToken
+	}
+	expiryUnix, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return roomToken{}, errInvalidToken
+	}
+	maxPeers, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return roomToken{}, errInvalidToken
+	}
+	tok := roomToken{roomID: fields[0], expiry: time.Unix(expiryUnix, 0), maxPeers: maxPeers}
+	if time.Now().After(tok.expiry) {
+		return roomToken{}, errTokenExpired
+	}
+	return tok, nil
+}
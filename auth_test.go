@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifyRoomTokenRoundTrip(t *testing.T) {
+	secret := []byte("test-secret")
+	tok := signRoomToken(secret, "room-1", time.Now().Add(time.Hour), 8)
+
+	got, err := verifyRoomToken(secret, tok)
+	if err != nil {
+		t.Fatalf("verifyRoomToken: %v", err)
+	}
+	if got.roomID != "room-1" || got.maxPeers != 8 {
+		t.Fatalf("got %+v, want roomID=room-1 maxPeers=8", got)
+	}
+}
+
+func TestVerifyRoomTokenExpired(t *testing.T) {
+	secret := []byte("test-secret")
+	tok := signRoomToken(secret, "room-1", time.Now().Add(-time.Minute), 8)
+
+	if _, err := verifyRoomToken(secret, tok); err != errTokenExpired {
+		t.Fatalf("got err %v, want errTokenExpired", err)
+	}
+}
+
+func TestVerifyRoomTokenWrongSecret(t *testing.T) {
+	tok := signRoomToken([]byte("right-secret"), "room-1", time.Now().Add(time.Hour), 8)
+
+	if _, err := verifyRoomToken([]byte("wrong-secret"), tok); err != errInvalidToken {
+		t.Fatalf("got err %v, want errInvalidToken", err)
+	}
+}
+
+func TestVerifyRoomTokenMalformed(t *testing.T) {
+	secret := []byte("test-secret")
+	cases := []string{
+		"",
+		"no-dot-in-here",
+		"not-base64!.alsonotbase64!",
+		signRoomToken(secret, "room-1", time.Now().Add(time.Hour), 8) + "tampered",
+	}
+	for _, tok := range cases {
+		if _, err := verifyRoomToken(secret, tok); err == nil {
+			t.Errorf("verifyRoomToken(%q): got nil error, want one", tok)
+		}
+	}
+}
+
+func TestMatchOrigin(t *testing.T) {
+	cases := []struct {
+		pattern, origin string
+		want            bool
+	}{
+		{"*", "https://anything.example", true},
+		{"https://example.com", "https://example.com", true},
+		{"https://example.com", "https://evil.com", false},
+		{"https://*.example.com", "https://chat.example.com", true},
+		{"https://*.example.com", "https://example.com", true},
+		{"https://*.example.com", "https://example.com.evil.com", false},
+		{"https://*.example.com", "https://notexample.com", false},
+		{"https://*.example.com", "http://chat.example.com", false},
+	}
+	for _, c := range cases {
+		if got := matchOrigin(c.pattern, c.origin); got != c.want {
+			t.Errorf("matchOrigin(%q, %q) = %v, want %v", c.pattern, c.origin, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import "encoding/json"
+
+// Envelope is a signaling message relayed between signal instances that
+// share a room via a RoomBackend. Body is the already-marshaled message
+// (it carries its own "from"/"to" fields); Origin and To exist so a
+// backend can route and a receiving instance can dedupe its own publishes.
+type Envelope struct {
+	RoomID string          `json:"room"`
+	Origin string          `json:"origin"` // instance ID that published this
+	To     string          `json:"to,omitempty"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// RoomBackend lets multiple signal instances behind a load balancer serve
+// the same room. Each instance keeps delivering to its own locally
+// connected peers directly; the backend is only responsible for relaying
+// to peers connected to other instances and for tracking presence so every
+// instance's roster reflects the whole room, not just its own peers.
+type RoomBackend interface {
+	// Publish fans env out to every instance subscribed to its room,
+	// including the publisher (see Envelope.Origin for self-dedup).
+	Publish(env Envelope) error
+	// Subscribe returns envelopes published to roomID and a cancel func
+	// that releases the subscription. Safe to call once per room per
+	// instance; callers are expected to fan the channel out locally.
+	Subscribe(roomID string) (ch <-chan Envelope, cancel func())
+	// Join, Leave and Heartbeat maintain this instance's contribution to
+	// roomID's presence set. Heartbeat must be called periodically for a
+	// peer to stay present in backends that expire presence via TTL.
+	Join(roomID, peerID string) error
+	Leave(roomID, peerID string) error
+	Heartbeat(roomID, peerID string) error
+	// Roster returns every peer ID currently present in roomID across all
+	// instances.
+	Roster(roomID string) ([]string, error)
+}
+
+// backend is the active RoomBackend for this process, chosen by -backend.
+var backend RoomBackend = newMemoryBackend()
+
+// instanceID identifies this process to the backend so it can recognize
+// (and skip re-delivering) its own publishes.
+var instanceID = mustPeerID()
+
+func mustPeerID() string {
+	id, err := newPeerID()
+	if err != nil {
+		// crypto/rand failing at startup means the process can't safely
+		// mint peer IDs either; there's nothing useful left to do.
+		panic("generate instance id: " + err.Error())
+	}
+	return id
+}
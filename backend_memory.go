@@ -0,0 +1,96 @@
+package main
+
+import "sync"
+
+// memoryBackend is the default, single-process RoomBackend: Publish hands
+// envelopes straight to this process's own subscribers and presence lives
+// in a plain map. This reproduces Ghost Chat's original single-instance
+// behavior and requires no external dependency.
+type memoryBackend struct {
+	mu   sync.Mutex
+	subs map[string][]chan Envelope
+
+	rosterMu sync.Mutex
+	roster   map[string][]string // room -> peer IDs, in join order
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{
+		subs:   make(map[string][]chan Envelope),
+		roster: make(map[string][]string),
+	}
+}
+
+func (b *memoryBackend) Publish(env Envelope) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// Held for the whole send, not just the slice copy: sends are
+	// non-blocking (buffered chan with a default case) so this stays
+	// cheap, and it keeps Subscribe's cancel from closing a channel out
+	// from under a send in flight.
+	for _, ch := range b.subs[env.RoomID] {
+		select {
+		case ch <- env:
+		default:
+			// Slow subscriber; drop rather than block other rooms.
+		}
+	}
+	return nil
+}
+
+func (b *memoryBackend) Subscribe(roomID string) (<-chan Envelope, func()) {
+	ch := make(chan Envelope, 32)
+	b.mu.Lock()
+	b.subs[roomID] = append(b.subs[roomID], ch)
+	b.mu.Unlock()
+
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		chans := b.subs[roomID]
+		for i, c := range chans {
+			if c == ch {
+				b.subs[roomID] = append(chans[:i], chans[i+1:]...)
+				break
+			}
+		}
+		if len(b.subs[roomID]) == 0 {
+			delete(b.subs, roomID)
+		}
+		close(ch)
+	}
+	return ch, cancel
+}
+
+func (b *memoryBackend) Join(roomID, peerID string) error {
+	b.rosterMu.Lock()
+	defer b.rosterMu.Unlock()
+	b.roster[roomID] = append(b.roster[roomID], peerID)
+	return nil
+}
+
+func (b *memoryBackend) Leave(roomID, peerID string) error {
+	b.rosterMu.Lock()
+	defer b.rosterMu.Unlock()
+	peers := b.roster[roomID]
+	for i, id := range peers {
+		if id == peerID {
+			b.roster[roomID] = append(peers[:i], peers[i+1:]...)
+			break
+		}
+	}
+	if len(b.roster[roomID]) == 0 {
+		delete(b.roster, roomID)
+	}
+	return nil
+}
+
+func (b *memoryBackend) Heartbeat(roomID, peerID string) error { return nil }
+
+func (b *memoryBackend) Roster(roomID string) ([]string, error) {
+	b.rosterMu.Lock()
+	defer b.rosterMu.Unlock()
+	peers := make([]string, len(b.roster[roomID]))
+	copy(peers, b.roster[roomID])
+	return peers, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// presenceTTL bounds how long a peer stays in a room's Redis presence
+	// set without a heartbeat; it's the backstop for instances that crash
+	// without calling Leave.
+	presenceTTL    = 30 * time.Second
+	redisOpTimeout = 2 * time.Second
+)
+
+// redisBackend is a RoomBackend that fans messages out over Redis pub/sub
+// so multiple signal instances behind a load balancer can serve the same
+// room. Presence lives in a per-room sorted set keyed by expiry time, which
+// gives TTL-style expiry without needing per-member key TTLs.
+type redisBackend struct {
+	client *redis.Client
+}
+
+func newRedisBackend(addr string) *redisBackend {
+	return &redisBackend{client: redis.NewClient(&redis.Options{Addr: addr})}
+}
+
+func roomChannel(roomID string) string     { return "ghostchat:room:" + roomID }
+func roomPresenceKey(roomID string) string { return "ghostchat:presence:" + roomID }
+
+func (b *redisBackend) Publish(env Envelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	return b.client.Publish(ctx, roomChannel(env.RoomID), data).Err()
+}
+
+// Subscribe relies on go-redis's PubSub.Channel(), which reconnects and
+// resubscribes on its own after a connection drop, so a Redis restart
+// doesn't require us to tear rooms down.
+func (b *redisBackend) Subscribe(roomID string) (<-chan Envelope, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ps := b.client.Subscribe(ctx, roomChannel(roomID))
+	out := make(chan Envelope, 32)
+
+	go func() {
+		defer close(out)
+		for msg := range ps.Channel() {
+			var env Envelope
+			if err := json.Unmarshal([]byte(msg.Payload), &env); err != nil {
+				logger.Warn("redis backend: dropping malformed envelope", "room", roomID, "error", err)
+				continue
+			}
+			out <- env
+		}
+	}()
+
+	return out, func() {
+		cancel()
+		ps.Close()
+	}
+}
+
+func (b *redisBackend) Join(roomID, peerID string) error {
+	return b.Heartbeat(roomID, peerID)
+}
+
+func (b *redisBackend) Heartbeat(roomID, peerID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	score := float64(time.Now().Add(presenceTTL).Unix())
+	return b.client.ZAdd(ctx, roomPresenceKey(roomID), redis.Z{Score: score, Member: peerID}).Err()
+}
+
+func (b *redisBackend) Leave(roomID, peerID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	return b.client.ZRem(ctx, roomPresenceKey(roomID), peerID).Err()
+}
+
+func (b *redisBackend) Roster(roomID string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	now := fmt.Sprintf("%d", time.Now().Unix())
+	return b.client.ZRangeByScore(ctx, roomPresenceKey(roomID), &redis.ZRangeBy{
+		Min: now,
+		Max: "+inf",
+	}).Result()
+}
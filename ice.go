@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// stunURIs collects repeatable -stun-uri flag values.
+type stunURIs []string
+
+func (s *stunURIs) String() string { return strings.Join(*s, ",") }
+
+func (s *stunURIs) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+var (
+	turnURI     string
+	turnSecret  string
+	turnTTL     = 12 * time.Hour
+	stunURIList stunURIs
+)
+
+// iceServer mirrors the shape RTCPeerConnection expects for one entry of
+// its iceServers configuration.
+type iceServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// turnCredentials implements the REST API TURN credential scheme used by
+// coturn et al.: username is "<unix-expiry>:<user>" and the password is the
+// base64-encoded HMAC-SHA1 of the username under the shared secret.
+func turnCredentials(secret, user string, expiry time.Time) (username, password string) {
+	username = fmt.Sprintf("%d:%s", expiry.Unix(), user)
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	password = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return username, password
+}
+
+func handleICE(w http.ResponseWriter, r *http.Request) {
+	if !connLimits.allow(clientIP(r)) {
+		writeError(w, http.StatusTooManyRequests, "rate_limited")
+		return
+	}
+
+	var servers []iceServer
+	for _, uri := range stunURIList {
+		servers = append(servers, iceServer{URLs: []string{uri}})
+	}
+
+	if turnURI != "" && turnSecret != "" {
+		user := r.URL.Query().Get("user")
+		if user == "" {
+			user = "ghostchat"
+		}
+		username, password := turnCredentials(turnSecret, user, time.Now().Add(turnTTL))
+		servers = append(servers, iceServer{
+			URLs:       []string{turnURI},
+			Username:   username,
+			Credential: password,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"iceServers": servers})
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/lmittmann/tint"
+)
+
+var logger = slog.Default()
+
+// initLogger builds the process-wide structured logger: JSON for
+// production log pipelines, colorized text for local development.
+func initLogger(format, level string) *slog.Logger {
+	lvl := parseLogLevel(level)
+
+	var handler slog.Handler
+	if format == "json" {
+		handler = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: lvl})
+	} else {
+		handler = tint.NewHandler(os.Stdout, &tint.Options{Level: lvl})
+	}
+	return slog.New(handler)
+}
+
+func parseLogLevel(s string) slog.Level {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
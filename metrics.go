@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	metricRooms = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ghostchat_rooms",
+		Help: "Number of currently active rooms.",
+	})
+
+	metricPeers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ghostchat_peers",
+		Help: "Number of currently connected peers across all rooms.",
+	})
+
+	metricMessagesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ghostchat_messages_total",
+		Help: "Signaling messages processed, by direction.",
+	}, []string{"direction"})
+
+	metricUpgradeFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ghostchat_upgrade_failures_total",
+		Help: "WebSocket upgrade attempts rejected before completion, by reason.",
+	}, []string{"reason"})
+
+	metricMessageSizeBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ghostchat_message_size_bytes",
+		Help:    "Size of inbound signaling messages in bytes.",
+		Buckets: prometheus.ExponentialBuckets(32, 2, 10),
+	})
+)
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	writeWait     = 10 * time.Second
+	pongWait      = 60 * time.Second
+	pingPeriod    = (pongWait * 9) / 10
+	sendQueueSize = 16
+)
+
+// allPeers tracks every peer connected to this instance, across all rooms,
+// so a graceful shutdown can notify and drain them without walking rooms.
+// peerWG mirrors allPeers as a WaitGroup so shutdown can block until every
+// peer has actually disconnected: http.Server.Shutdown doesn't wait on
+// hijacked connections, which is what every upgraded WebSocket is.
+var (
+	allPeersMu sync.Mutex
+	allPeers   = make(map[*Peer]bool)
+	peerWG     sync.WaitGroup
+)
+
+func registerPeer(p *Peer) {
+	allPeersMu.Lock()
+	allPeers[p] = true
+	allPeersMu.Unlock()
+	peerWG.Add(1)
+}
+
+func unregisterPeer(p *Peer) {
+	allPeersMu.Lock()
+	delete(allPeers, p)
+	allPeersMu.Unlock()
+	peerWG.Done()
+}
+
+// Peer is a single joined WebSocket connection within a Room. Writes never
+// happen directly against ws from multiple goroutines; callers enqueue
+// messages and a dedicated writePump goroutine is the connection's sole
+// writer, per gorilla/websocket's single-writer requirement.
+type Peer struct {
+	id      string
+	ws      *websocket.Conn
+	send    chan []byte
+	closeCh chan closeRequest
+
+	closeOnce sync.Once
+	done      chan struct{}
+
+	connectedAt time.Time
+	msgs        atomic.Int64
+	bytes       atomic.Int64
+}
+
+// closeRequest asks the writer goroutine to emit a close frame with the
+// given code/reason before the connection is torn down.
+type closeRequest struct {
+	code int
+	text string
+}
+
+// newPeer wraps ws, wires up read deadlines/pong handling, and starts the
+// peer's writer goroutine.
+func newPeer(id string, ws *websocket.Conn) *Peer {
+	p := &Peer{
+		id:          id,
+		ws:          ws,
+		send:        make(chan []byte, sendQueueSize),
+		closeCh:     make(chan closeRequest, 1),
+		done:        make(chan struct{}),
+		connectedAt: time.Now(),
+	}
+
+	ws.SetReadDeadline(time.Now().Add(pongWait))
+	ws.SetPongHandler(func(string) error {
+		ws.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	registerPeer(p)
+	go p.writePump()
+	return p
+}
+
+// enqueue queues data for delivery without blocking. If the peer's send
+// queue is full, it's a slow consumer: evict it rather than stall the
+// room's broadcast for everyone else.
+func (p *Peer) enqueue(data []byte) {
+	select {
+	case p.send <- data:
+	case <-p.done:
+	default:
+		p.close()
+	}
+}
+
+// requestClose asks the writer goroutine to send a close frame with the
+// given code/reason before the connection goes away. Safe to call from the
+// reader goroutine since the actual write happens on the writer.
+func (p *Peer) requestClose(code int, text string) {
+	select {
+	case p.closeCh <- closeRequest{code, text}:
+	default:
+	}
+}
+
+// awaitClose blocks until the writer has finished handling a prior
+// requestClose (and torn the connection down), or until writeWait has
+// elapsed. Callers that force-close a peer right after requestClose must
+// go through this first: otherwise they race the writer for ws.Close and
+// the close frame is often never sent.
+func (p *Peer) awaitClose() {
+	select {
+	case <-p.done:
+	case <-time.After(writeWait + time.Second):
+	}
+}
+
+// recordInbound tracks a message read from this peer for per-connection
+// logging (join/leave summaries) and metrics.
+func (p *Peer) recordInbound(n int) {
+	p.msgs.Add(1)
+	p.bytes.Add(int64(n))
+}
+
+func (p *Peer) messageCount() int64         { return p.msgs.Load() }
+func (p *Peer) byteCount() int64            { return p.bytes.Load() }
+func (p *Peer) connectedFor() time.Duration { return time.Since(p.connectedAt) }
+
+// close tears the peer down: its writer goroutine exits and the underlying
+// connection is closed, which in turn unblocks the read loop in handleWS.
+func (p *Peer) close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.ws.Close()
+		unregisterPeer(p)
+	})
+}
+
+// writePump is the connection's only writer. It drains the send queue,
+// emits periodic pings so dead connections are detected promptly, and
+// handles close requests.
+func (p *Peer) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		p.close()
+	}()
+
+	for {
+		select {
+		case msg := <-p.send:
+			p.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := p.ws.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case req := <-p.closeCh:
+			// Flush anything already queued ahead of the close frame (e.g.
+			// a shutdown notice enqueued just before requestClose) so the
+			// peer sees it before the connection goes away.
+		drain:
+			for {
+				select {
+				case msg := <-p.send:
+					p.ws.SetWriteDeadline(time.Now().Add(writeWait))
+					if err := p.ws.WriteMessage(websocket.TextMessage, msg); err != nil {
+						return
+					}
+				default:
+					break drain
+				}
+			}
+			p.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			p.ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(req.code, req.text))
+			return
+		case <-ticker.C:
+			p.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := p.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newServerConn spins up a one-shot WebSocket server and dials it, handing
+// back the server-side connection peer.go operates on and the client-side
+// connection a test can read from.
+func newServerConn(t *testing.T) (server *websocket.Conn, client *websocket.Conn) {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	connCh := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		connCh <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+	client, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	select {
+	case server = <-connCh:
+	case <-time.After(2 * time.Second):
+		t.Fatal("server never accepted the connection")
+	}
+	return server, client
+}
+
+// TestPeerEnqueueConcurrentWritesNotCorrupted guards the reason this
+// request exists: writePump must be the connection's sole writer, so N
+// goroutines calling enqueue concurrently never interleave or corrupt a
+// frame on the wire.
+func TestPeerEnqueueConcurrentWritesNotCorrupted(t *testing.T) {
+	origSize := sendQueueSize
+	sendQueueSize = 64 // comfortably above n, so this test isn't about eviction
+	defer func() { sendQueueSize = origSize }()
+
+	serverConn, client := newServerConn(t)
+	p := newPeer("concurrent-peer", serverConn)
+	defer p.close()
+
+	const n = 50
+	want := make(map[string]bool, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		msg := strings.Repeat("x", i%8) + "-payload-" + string(rune('a'+i%26))
+		want[msg] = true
+		wg.Add(1)
+		go func(msg string) {
+			defer wg.Done()
+			p.enqueue([]byte(msg))
+		}(msg)
+	}
+	wg.Wait()
+
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	got := make(map[string]bool, n)
+	for i := 0; i < n; i++ {
+		_, raw, err := client.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage after %d of %d: %v", i, n, err)
+		}
+		if !want[string(raw)] {
+			t.Fatalf("received corrupt/unexpected frame: %q", raw)
+		}
+		got[string(raw)] = true
+	}
+	if len(got) != n {
+		t.Fatalf("got %d distinct intact messages, want %d", len(got), n)
+	}
+}
+
+// TestPeerEnqueueEvictsSlowConsumer guards the other half of this request:
+// a peer that isn't draining its send queue must be evicted, not allowed
+// to stall enqueue (and so the room's broadcast) for everyone else.
+func TestPeerEnqueueEvictsSlowConsumer(t *testing.T) {
+	serverConn, _ := newServerConn(t)
+
+	// Built directly rather than via newPeer, so no writePump is draining
+	// send: the queue really does fill up after its capacity is reached.
+	p := &Peer{
+		id:   "slow-peer",
+		ws:   serverConn,
+		send: make(chan []byte, 2),
+		done: make(chan struct{}),
+	}
+	registerPeer(p) // close() unregisters; keep allPeers/peerWG balanced
+
+	p.enqueue([]byte("one"))
+	p.enqueue([]byte("two"))
+
+	evicted := make(chan struct{})
+	go func() {
+		p.enqueue([]byte("three")) // queue is full: must evict, not block
+		close(evicted)
+	}()
+
+	select {
+	case <-evicted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("enqueue blocked on a full send queue instead of evicting the slow consumer")
+	}
+
+	select {
+	case <-p.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("peer was not closed after its send queue filled")
+	}
+}
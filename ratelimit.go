@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiterTTL is how long an IP's limiter can sit unused before a sweep
+// evicts it. Without eviction, every distinct IP that ever reaches this
+// server (trivially varied by an attacker, or just many legitimate users
+// over time) leaves a permanent entry, turning the rate limiter itself
+// into an unbounded-memory target.
+const limiterTTL = 10 * time.Minute
+
+// ipLimiters hands out a token-bucket limiter per client IP, used both to
+// throttle upgrade attempts and to throttle inbound messages on connections
+// already established. Entries unused for limiterTTL are swept away by a
+// background goroutine started in newIPLimiters.
+type ipLimiters struct {
+	mu       sync.Mutex
+	perSec   float64
+	burst    int
+	limiters map[string]*limiterEntry
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newIPLimiters(perSec float64, burst int) *ipLimiters {
+	l := &ipLimiters{perSec: perSec, burst: burst, limiters: make(map[string]*limiterEntry)}
+	go l.sweepLoop()
+	return l
+}
+
+func (l *ipLimiters) get(ip string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.limiters[ip]
+	if !ok {
+		e = &limiterEntry{limiter: rate.NewLimiter(rate.Limit(l.perSec), l.burst)}
+		l.limiters[ip] = e
+	}
+	e.lastSeen = time.Now()
+	return e.limiter
+}
+
+// allow reports whether a request from ip is within its rate limit. A nil
+// receiver (rate limiting disabled) always allows.
+func (l *ipLimiters) allow(ip string) bool {
+	if l == nil {
+		return true
+	}
+	return l.get(ip).Allow()
+}
+
+// sweepLoop periodically evicts limiters that haven't been touched in
+// limiterTTL, so memory use tracks active clients rather than every IP
+// ever seen.
+func (l *ipLimiters) sweepLoop() {
+	ticker := time.NewTicker(limiterTTL / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		cutoff := time.Now().Add(-limiterTTL)
+		l.mu.Lock()
+		for ip, e := range l.limiters {
+			if e.lastSeen.Before(cutoff) {
+				delete(l.limiters, ip)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// clientIP extracts the request's remote IP, stripping the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
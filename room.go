@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Room holds the set of peers signaling with one another under a shared
+// room ID. Peers are tracked by ID rather than by connection so that
+// directed messages (offer/answer/ICE) can address a specific peer.
+//
+// A Room only ever holds the peers connected to *this* instance. When
+// -backend is redis, multiple instances can serve the same room: messages
+// and presence for peers connected elsewhere flow through backend, and
+// relayLoop fans them out to this instance's local peers.
+type Room struct {
+	mu       sync.RWMutex
+	id       string
+	peers    map[string]*Peer
+	order    []string // local join order, for roster broadcasts
+	maxPeers int
+
+	unsubscribe func()
+}
+
+var (
+	rooms   = make(map[string]*Room)
+	roomsMu sync.RWMutex
+)
+
+// getRoom returns the room for id, creating it (and subscribing it to the
+// backend) with the given peer cap if it doesn't exist yet. maxPeers on an
+// existing room is left untouched.
+func getRoom(id string, maxPeers int) *Room {
+	roomsMu.Lock()
+	defer roomsMu.Unlock()
+	if r, ok := rooms[id]; ok {
+		return r
+	}
+	r := &Room{id: id, peers: make(map[string]*Peer), maxPeers: maxPeers}
+	ch, cancel := backend.Subscribe(id)
+	r.unsubscribe = cancel
+	go r.relayLoop(ch)
+
+	rooms[id] = r
+	metricRooms.Set(float64(len(rooms)))
+	return r
+}
+
+// tryJoin atomically checks the room's peer cap and, if there's room,
+// reserves p's slot by adding it to r.peers, registers it with the
+// backend's presence set, and broadcasts the updated roster. Callers must
+// treat a false result as a rejected join: the peer was not added. Doing
+// the check and the insert under one lock (rather than full() followed by
+// a separate insert) closes the race where concurrent joins on a room near
+// its cap all observe room before any of them registers.
+func (r *Room) tryJoin(p *Peer) bool {
+	r.mu.Lock()
+	if len(r.peers) >= r.maxPeers {
+		r.mu.Unlock()
+		return false
+	}
+	r.peers[p.id] = p
+	r.order = append(r.order, p.id)
+	r.mu.Unlock()
+
+	if err := backend.Join(r.id, p.id); err != nil {
+		logger.Warn("backend join failed", "room", r.id, "peer", p.id, "error", err)
+	}
+	go r.heartbeatLoop(p)
+
+	metricPeers.Inc()
+	logger.Info("peer joined", "room", r.id, "peer", p.id)
+	r.broadcastRoster()
+	return true
+}
+
+// newPeerID generates a random 128-bit peer ID, hex-encoded.
+func newPeerID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// heartbeatLoop keeps p's backend presence entry alive until it disconnects.
+func (r *Room) heartbeatLoop(p *Peer) {
+	ticker := time.NewTicker(presenceTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			if err := backend.Heartbeat(r.id, p.id); err != nil {
+				logger.Warn("backend heartbeat failed", "room", r.id, "peer", p.id, "error", err)
+			}
+		}
+	}
+}
+
+// leave removes the peer from the room, tearing the room down (and
+// unsubscribing from the backend) if it's now empty, and otherwise
+// broadcasts the updated roster.
+func (r *Room) leave(p *Peer) {
+	r.mu.Lock()
+	delete(r.peers, p.id)
+	for i, id := range r.order {
+		if id == p.id {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+	empty := len(r.peers) == 0
+	r.mu.Unlock()
+
+	if err := backend.Leave(r.id, p.id); err != nil {
+		logger.Warn("backend leave failed", "room", r.id, "peer", p.id, "error", err)
+	}
+
+	metricPeers.Dec()
+	logger.Info("peer left", "room", r.id, "peer", p.id,
+		"messages", p.messageCount(), "bytes", p.byteCount(), "duration", p.connectedFor())
+
+	if empty {
+		roomsMu.Lock()
+		delete(rooms, r.id)
+		metricRooms.Set(float64(len(rooms)))
+		roomsMu.Unlock()
+		r.unsubscribe()
+	} else {
+		r.broadcastRoster()
+	}
+}
+
+// roster returns the full set of peer IDs present in the room across every
+// instance, with this instance's local peers first in their join order.
+func (r *Room) roster() []string {
+	r.mu.RLock()
+	local := make([]string, len(r.order))
+	copy(local, r.order)
+	r.mu.RUnlock()
+
+	global, err := backend.Roster(r.id)
+	if err != nil {
+		logger.Warn("backend roster fetch failed, using local view only", "room", r.id, "error", err)
+		return local
+	}
+
+	seen := make(map[string]bool, len(local))
+	peers := make([]string, 0, len(global))
+	for _, id := range local {
+		peers = append(peers, id)
+		seen[id] = true
+	}
+	for _, id := range global {
+		if !seen[id] {
+			peers = append(peers, id)
+			seen[id] = true
+		}
+	}
+	return peers
+}
+
+// broadcastRoster sends the full roster to every peer in the room.
+func (r *Room) broadcastRoster() {
+	r.broadcast("", map[string]interface{}{"type": "roster", "peers": r.roster()})
+}
+
+// broadcast delivers msg to every local peer in the room except from (pass
+// "" to address everyone), and publishes it so other instances relay it to
+// their own local peers.
+func (r *Room) broadcast(from string, msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		logger.Error("marshal broadcast message", "room", r.id, "error", err)
+		return
+	}
+
+	r.mu.RLock()
+	for id, p := range r.peers {
+		if id != from {
+			p.enqueue(data)
+			metricMessagesTotal.WithLabelValues("out").Inc()
+		}
+	}
+	r.mu.RUnlock()
+
+	r.publish(Envelope{RoomID: r.id, Origin: instanceID, Body: data})
+}
+
+// sendTo delivers msg to a single peer by ID: directly if it's connected to
+// this instance, otherwise via the backend in case it's on another one.
+func (r *Room) sendTo(to string, msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		logger.Error("marshal directed message", "room", r.id, "to", to, "error", err)
+		return
+	}
+
+	r.mu.RLock()
+	p, ok := r.peers[to]
+	r.mu.RUnlock()
+	if ok {
+		p.enqueue(data)
+		metricMessagesTotal.WithLabelValues("out").Inc()
+		return
+	}
+
+	r.publish(Envelope{RoomID: r.id, Origin: instanceID, To: to, Body: data})
+}
+
+func (r *Room) publish(env Envelope) {
+	if err := backend.Publish(env); err != nil {
+		logger.Warn("backend publish failed", "room", r.id, "error", err)
+	}
+}
+
+// relayLoop delivers envelopes published by other instances to this
+// instance's local peers. Envelopes this instance published itself are
+// skipped since they were already delivered directly in broadcast/sendTo.
+func (r *Room) relayLoop(ch <-chan Envelope) {
+	for env := range ch {
+		if env.Origin == instanceID {
+			continue
+		}
+		if env.To != "" {
+			r.deliverLocal(env.To, env.Body)
+		} else {
+			r.deliverLocalAll(env.Body)
+		}
+	}
+}
+
+func (r *Room) deliverLocal(to string, data []byte) {
+	r.mu.RLock()
+	p, ok := r.peers[to]
+	r.mu.RUnlock()
+	if ok {
+		p.enqueue(data)
+		metricMessagesTotal.WithLabelValues("out").Inc()
+	}
+}
+
+func (r *Room) deliverLocalAll(data []byte) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, p := range r.peers {
+		p.enqueue(data)
+		metricMessagesTotal.WithLabelValues("out").Inc()
+	}
+}
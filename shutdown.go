@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+)
+
+// reconnectHintMS is the backoff hint sent to clients on server_shutdown;
+// it's a separate (short) concern from -drain-timeout, which governs how
+// long *this* instance waits for peers to leave.
+const reconnectHintMS = 2000
+
+// notifyShutdown tells every locally connected peer the server is going
+// away and asks its writer goroutine to follow up with a close frame.
+func notifyShutdown(reason string) {
+	msg, err := json.Marshal(map[string]interface{}{
+		"type":               "server_shutdown",
+		"reason":             reason,
+		"reconnect_after_ms": reconnectHintMS,
+	})
+	if err != nil {
+		logger.Error("marshal shutdown notice", "error", err)
+		return
+	}
+
+	allPeersMu.Lock()
+	peers := make([]*Peer, 0, len(allPeers))
+	for p := range allPeers {
+		peers = append(peers, p)
+	}
+	allPeersMu.Unlock()
+
+	for _, p := range peers {
+		p.enqueue(msg)
+		p.requestClose(websocket.CloseGoingAway, reason)
+	}
+}
+
+// drained returns a channel that's closed once every registered peer has
+// disconnected. http.Server.Shutdown won't tell us this: it explicitly
+// doesn't wait for hijacked connections, and an upgraded WebSocket is one,
+// so draining has to be tracked ourselves via peerWG.
+func drained() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		peerWG.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// forceCloseRemaining drops any peers still connected after the drain
+// timeout elapses, so shutdown doesn't hang on stragglers.
+func forceCloseRemaining() {
+	allPeersMu.Lock()
+	peers := make([]*Peer, 0, len(allPeers))
+	for p := range allPeers {
+		peers = append(peers, p)
+	}
+	allPeersMu.Unlock()
+
+	for _, p := range peers {
+		p.close()
+	}
+}
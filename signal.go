@@ -1,105 +1,112 @@
 // Minimal WebSocket signaling server for Ghost Chat
 // Build: go build -o signal signal.go
 // Usage: ./signal [-port 8443] [-cert cert.pem] [-key key.pem]
-
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
-	"log"
 	"net/http"
-	"strings"
-	"sync"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
-}
-
-type Room struct {
-	mu     sync.RWMutex
-	peers  map[*websocket.Conn]bool
+	CheckOrigin: checkOrigin,
 }
 
 var (
-	rooms = make(map[string]*Room)
-	roomsMu sync.RWMutex
+	hmacSecret []byte
+	connLimits *ipLimiters // upgrade attempts and inbound messages, per IP
 )
 
-func getRoom(id string) *Room {
-	roomsMu.Lock()
-	defer roomsMu.Unlock()
-	if r, ok := rooms[id]; ok {
-		return r
-	}
-	r := &Room{peers: make(map[*websocket.Conn]bool)}
-	rooms[id] = r
-	return r
-}
-
-func (r *Room) join(ws *websocket.Conn) {
-	r.mu.Lock()
-	r.peers[ws] = true
-	count := len(r.peers)
-	r.mu.Unlock()
-
-	// Send peer count to new peer
-	ws.WriteJSON(map[string]interface{}{"type": "peers", "count": count})
-
-	// Notify others
-	r.broadcast(ws, map[string]string{"type": "join"})
+// writeError responds with a typed JSON error before any WebSocket upgrade
+// has taken place.
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"type": "error", "code": code})
 }
 
-func (r *Room) leave(ws *websocket.Conn) {
-	r.mu.Lock()
-	delete(r.peers, ws)
-	empty := len(r.peers) == 0
-	r.mu.Unlock()
-
-	if empty {
-		roomsMu.Lock()
-		delete(rooms, r)
-		roomsMu.Unlock()
-	} else {
-		r.broadcast(ws, map[string]string{"type": "leave"})
-	}
-}
-
-func (r *Room) broadcast(from *websocket.Conn, msg interface{}) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-	for peer := range r.peers {
-		if peer != from {
-			peer.WriteJSON(msg)
-		}
+func handleWS(w http.ResponseWriter, r *http.Request) {
+	ip := clientIP(r)
+	if !connLimits.allow(ip) {
+		metricUpgradeFailuresTotal.WithLabelValues("rate_limited").Inc()
+		writeError(w, http.StatusTooManyRequests, "rate_limited")
+		return
 	}
-}
 
-func handleWS(w http.ResponseWriter, r *http.Request) {
-	roomID := r.URL.Query().Get("room")
-	if roomID == "" {
-		http.Error(w, "Missing room", http.StatusBadRequest)
+	tok, err := verifyRoomToken(hmacSecret, r.URL.Query().Get("token"))
+	if err != nil {
+		metricUpgradeFailuresTotal.WithLabelValues("invalid_token").Inc()
+		writeError(w, http.StatusUnauthorized, "invalid_token")
 		return
 	}
 
+	room := getRoom(tok.roomID, tok.maxPeers)
+
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		metricUpgradeFailuresTotal.WithLabelValues("upgrade_error").Inc()
 		return
 	}
-	defer ws.Close()
 
-	room := getRoom(roomID)
-	room.join(ws)
-	defer room.leave(ws)
+	id, err := newPeerID()
+	if err != nil {
+		logger.Error("peer id generation failed", "error", err)
+		ws.Close()
+		return
+	}
+	peer := newPeer(id, ws)
+	defer peer.close()
+
+	// The capacity check has to happen after Upgrade (there's no socket to
+	// reject-with-a-status-code before that) and has to be the same atomic
+	// operation as inserting into r.peers, or concurrent joins on a room at
+	// its cap can all pass the check before any of them registers.
+	if !room.tryJoin(peer) {
+		metricUpgradeFailuresTotal.WithLabelValues("room_full").Inc()
+		peer.requestClose(websocket.ClosePolicyViolation, "room full")
+		peer.awaitClose()
+		return
+	}
+	defer room.leave(peer)
 
 	for {
-		var msg map[string]interface{}
-		if err := ws.ReadJSON(&msg); err != nil {
+		_, raw, err := ws.ReadMessage()
+		if err != nil {
 			break
 		}
-		room.broadcast(ws, msg)
+		if !connLimits.allow(ip) {
+			peer.requestClose(websocket.ClosePolicyViolation, "rate limit exceeded")
+			peer.awaitClose()
+			break
+		}
+
+		peer.recordInbound(len(raw))
+		metricMessagesTotal.WithLabelValues("in").Inc()
+		metricMessageSizeBytes.Observe(float64(len(raw)))
+
+		var msg map[string]interface{}
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			logger.Warn("dropping malformed message", "room", room.id, "peer", peer.id, "error", err)
+			continue
+		}
+		msg["from"] = peer.id
+
+		if to, ok := msg["to"].(string); ok && to != "" {
+			room.sendTo(to, msg)
+		} else {
+			room.broadcast(peer.id, msg)
+		}
 	}
 }
 
@@ -107,17 +114,107 @@ func main() {
 	port := flag.String("port", "8080", "Port to listen on")
 	cert := flag.String("cert", "", "TLS certificate file")
 	key := flag.String("key", "", "TLS key file")
+	origins := flag.String("allowed-origins", "", "Comma-separated list of allowed origins (supports *.domain wildcards)")
+	secret := flag.String("hmac-secret", "", "Shared secret for signing room-access tokens (required)")
+	rateLimit := flag.Float64("rate-limit", 5, "Max requests per second, per IP, for upgrades and inbound messages")
+	flag.DurationVar(&pongWait, "pong-wait", pongWait, "How long to wait for a pong before considering a peer dead")
+	flag.DurationVar(&writeWait, "write-wait", writeWait, "Write deadline for a single message or ping")
+	flag.IntVar(&sendQueueSize, "send-queue-size", sendQueueSize, "Per-peer outbound queue length before a slow consumer is evicted")
+	flag.StringVar(&turnURI, "turn-uri", "", "TURN server URI handed out by /ice (e.g. turn:turn.example.com:3478)")
+	flag.StringVar(&turnSecret, "turn-secret", "", "Shared secret for the TURN server's REST API credential scheme")
+	flag.DurationVar(&turnTTL, "turn-ttl", turnTTL, "Lifetime of credentials vended by /ice")
+	flag.Var(&stunURIList, "stun-uri", "STUN server URI handed out by /ice (repeatable)")
+	logLevel := flag.String("log-level", "info", "Log level: debug, info, warn, error")
+	logFormat := flag.String("log-format", "text", "Log format: text (colorized, for dev) or json (for production)")
+	debugAddr := flag.String("debug-addr", "", "If set, serve net/http/pprof on this address (e.g. localhost:6060)")
+	backendKind := flag.String("backend", "memory", "Room backend: memory (single instance) or redis (horizontal scaling)")
+	redisAddr := flag.String("redis-addr", "localhost:6379", "Redis address, used when -backend=redis")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "How long to wait for peers to disconnect on shutdown before forcing it")
 	flag.Parse()
 
+	logger = initLogger(*logFormat, *logLevel)
+
+	if *secret == "" {
+		logger.Error("-hmac-secret is required")
+		os.Exit(1)
+	}
+	hmacSecret = []byte(*secret)
+	allowedOrigins = parseAllowedOrigins(*origins)
+	connLimits = newIPLimiters(*rateLimit, int(*rateLimit)*2)
+	pingPeriod = (pongWait * 9) / 10
+
+	switch *backendKind {
+	case "memory":
+		backend = newMemoryBackend()
+	case "redis":
+		backend = newRedisBackend(*redisAddr)
+	default:
+		logger.Error("unknown -backend", "backend", *backendKind)
+		os.Exit(1)
+	}
+
 	http.HandleFunc("/", handleWS)
+	http.HandleFunc("/ice", handleICE)
+	http.Handle("/metrics", promhttp.Handler())
+
+	if *debugAddr != "" {
+		debugMux := http.NewServeMux()
+		debugMux.HandleFunc("/debug/pprof/", pprof.Index)
+		debugMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		debugMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		debugMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		debugMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		go func() {
+			logger.Info("pprof listening", "addr", *debugAddr)
+			logger.Error("pprof server exited", "error", http.ListenAndServe(*debugAddr, debugMux))
+		}()
+	}
 
 	addr := ":" + *port
-	log.Printf("Ghost signal server on %s", addr)
+	srv := &http.Server{Addr: addr}
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		<-sigCh
+
+		logger.Info("shutdown signal received, draining connections", "timeout", *drainTimeout)
+		notifyShutdown("server shutting down")
+
+		ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+		defer cancel()
+		// Shutdown stops the listener and returns almost immediately: it
+		// doesn't wait for hijacked connections, so it's not the drain
+		// signal. Actual draining is tracked via drained()/peerWG below.
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Warn("http server shutdown error", "error", err)
+		}
 
+		select {
+		case <-drained():
+			logger.Info("all peers disconnected")
+		case <-ctx.Done():
+			logger.Warn("drain timeout exceeded, forcing remaining connections closed")
+			forceCloseRemaining()
+		}
+		close(shutdownDone)
+	}()
+
+	logger.Info("ghost signal server starting", "addr", addr)
+
+	var err error
 	if *cert != "" && *key != "" {
-		log.Fatal(http.ListenAndServeTLS(addr, *cert, *key, nil))
+		err = srv.ListenAndServeTLS(*cert, *key)
 	} else {
-		log.Printf("Warning: Running without TLS")
-		log.Fatal(http.ListenAndServe(addr, nil))
+		logger.Warn("running without TLS")
+		err = srv.ListenAndServe()
 	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("server exited", "error", err)
+		os.Exit(1)
+	}
+
+	<-shutdownDone
+	logger.Info("shutdown complete")
 }